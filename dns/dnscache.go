@@ -0,0 +1,195 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package dns
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheEntry is one resolved (or negatively resolved) answer held by the
+// resolver cache. A zero-value rrs together with negative == true records
+// an RFC 2308 negative answer (NXDOMAIN or NODATA).
+type cacheEntry struct {
+	cname    string
+	rrs      []dnsRR
+	negative bool
+	expires  time.Time
+}
+
+func (e *cacheEntry) expired(now time.Time) bool { return now.After(e.expires) }
+
+// dnsCache is a process-wide, TTL-aware cache in front of tryOneName,
+// keyed by canonical (lower-cased, qtype-qualified) name. It answers the
+// long-standing TODO at the top of this file about adding a small cache.
+type dnsCache struct {
+	mu      sync.RWMutex
+	entries map[string]*cacheEntry
+
+	// negTTLCap bounds how long a negative answer is trusted, regardless
+	// of what the authoritative SOA MINIMUM says.
+	negTTLCap time.Duration
+
+	sf singleflight
+}
+
+var resolverCache = newDNSCache(5 * time.Minute)
+
+func newDNSCache(negTTLCap time.Duration) *dnsCache {
+	return &dnsCache{entries: make(map[string]*cacheEntry), negTTLCap: negTTLCap}
+}
+
+func cacheKey(name string, qtype uint16) string {
+	return strconv.Itoa(int(qtype)) + "/" + strings.ToLower(name)
+}
+
+// get returns the cached answer for (name, qtype), if any and not expired.
+func (c *dnsCache) get(name string, qtype uint16) (*cacheEntry, bool) {
+	c.mu.RLock()
+	e, ok := c.entries[cacheKey(name, qtype)]
+	c.mu.RUnlock()
+	if !ok || e.expired(time.Now()) {
+		return nil, false
+	}
+	return e, true
+}
+
+// put stores a positive answer, evicting at the minimum TTL across rrs.
+func (c *dnsCache) put(name string, qtype uint16, cname string, rrs []dnsRR) {
+	ttl := minTTL(rrs)
+	if ttl == 0 {
+		return
+	}
+	c.mu.Lock()
+	c.entries[cacheKey(name, qtype)] = &cacheEntry{
+		cname:   cname,
+		rrs:     rrs,
+		expires: time.Now().Add(time.Duration(ttl) * time.Second),
+	}
+	c.mu.Unlock()
+}
+
+// defaultNegTTL is used when a negative answer carries no SOA MINIMUM to
+// bound it by (e.g. the upstream's AUTHORITY section was empty, or the
+// "answer" came from a NODATA response with nothing to scan), so the
+// entry is still worth a short cache instead of being skipped entirely.
+const defaultNegTTL = 30 * time.Second
+
+// putNegative stores an RFC 2308 negative answer, good until the SOA
+// MINIMUM (capped by negTTLCap, floored by defaultNegTTL if the server
+// gave us no MINIMUM to work with).
+func (c *dnsCache) putNegative(name string, qtype uint16, soaMinimum uint32) {
+	ttl := time.Duration(soaMinimum) * time.Second
+	if ttl == 0 {
+		ttl = defaultNegTTL
+	}
+	if ttl > c.negTTLCap {
+		ttl = c.negTTLCap
+	}
+	c.mu.Lock()
+	c.entries[cacheKey(name, qtype)] = &cacheEntry{negative: true, expires: time.Now().Add(ttl)}
+	c.mu.Unlock()
+}
+
+// Flush discards every cached entry, e.g. after check_black reports a
+// poisoned answer that must not keep being served.
+func (c *dnsCache) Flush() {
+	c.mu.Lock()
+	c.entries = make(map[string]*cacheEntry)
+	c.mu.Unlock()
+}
+
+// Stats is a point-in-time snapshot of cache occupancy for diagnostics.
+type Stats struct {
+	Entries  int
+	Negative int
+}
+
+func (c *dnsCache) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s := Stats{Entries: len(c.entries)}
+	for _, e := range c.entries {
+		if e.negative {
+			s.Negative++
+		}
+	}
+	return s
+}
+
+func minTTL(rrs []dnsRR) uint32 {
+	var min uint32
+	for i, rr := range rrs {
+		ttl := rr.Header().Ttl
+		if i == 0 || ttl < min {
+			min = ttl
+		}
+	}
+	return min
+}
+
+// negativeTTL extracts the MINIMUM field from the first SOA record in
+// msg's AUTHORITY section, used to bound negative-cache TTLs per RFC
+// 2308 section 5. The SOA for a genuine NXDOMAIN/NODATA answer lives in
+// the authority section, not the (empty, for these answers) answer
+// section -- passing rrs from answer()'s ANSWER-section result here
+// would always return 0.
+func negativeTTL(msg *dnsMsg) uint32 {
+	if msg == nil {
+		return 0
+	}
+	for _, rr := range msg.ns {
+		if soa, ok := rr.(*dnsRR_SOA); ok {
+			return soa.Minttl
+		}
+	}
+	return 0
+}
+
+// singleflight coalesces concurrent cache misses for the same key so a
+// cold proxy startup (many goroutines all missing the cache for the same
+// hostname) fans out a single query rather than one per caller.
+type singleflight struct {
+	mu    sync.Mutex
+	calls map[string]*sfCall
+}
+
+type sfCall struct {
+	wg       sync.WaitGroup
+	cname    string
+	rrs      []dnsRR
+	err      error
+}
+
+// do runs fn for key if no call is already in flight, otherwise it waits
+// for the in-flight call and shares its result.
+func (g *singleflight) do(key string, fn func() (string, []dnsRR, error)) (string, []dnsRR, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*sfCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.cname, c.rrs, c.err
+	}
+	c := new(sfCall)
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.cname, c.rrs, c.err = fn()
+	c.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	return c.cname, c.rrs, c.err
+}
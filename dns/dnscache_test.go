@@ -0,0 +1,60 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package dns
+
+import "testing"
+
+func TestMinTTL(t *testing.T) {
+	rrs := []dnsRR{
+		&dnsRR_A{Hdr: dnsRR_Header{Ttl: 300}},
+		&dnsRR_A{Hdr: dnsRR_Header{Ttl: 60}},
+		&dnsRR_A{Hdr: dnsRR_Header{Ttl: 120}},
+	}
+	if got := minTTL(rrs); got != 60 {
+		t.Errorf("minTTL() = %d, want 60", got)
+	}
+}
+
+func TestMinTTLEmpty(t *testing.T) {
+	if got := minTTL(nil); got != 0 {
+		t.Errorf("minTTL(nil) = %d, want 0", got)
+	}
+}
+
+func TestNegativeTTLReadsAuthoritySOA(t *testing.T) {
+	msg := &dnsMsg{
+		ns: []dnsRR{
+			&dnsRR_SOA{Hdr: dnsRR_Header{Rrtype: dnsTypeSOA}, Minttl: 1800},
+		},
+	}
+	if got := negativeTTL(msg); got != 1800 {
+		t.Errorf("negativeTTL() = %d, want 1800", got)
+	}
+}
+
+func TestNegativeTTLIgnoresAnswerSection(t *testing.T) {
+	// A SOA in the ANSWER section (as answer() returns via addrs) must
+	// not be mistaken for the AUTHORITY section's negative-caching SOA;
+	// negativeTTL only looks at msg.ns.
+	msg := &dnsMsg{
+		answer: []dnsRR{
+			&dnsRR_SOA{Hdr: dnsRR_Header{Rrtype: dnsTypeSOA}, Minttl: 1800},
+		},
+	}
+	if got := negativeTTL(msg); got != 0 {
+		t.Errorf("negativeTTL() = %d, want 0 when the SOA is only in the answer section", got)
+	}
+}
+
+func TestNegativeTTLNoSOA(t *testing.T) {
+	if got := negativeTTL(&dnsMsg{}); got != 0 {
+		t.Errorf("negativeTTL() = %d, want 0 with no SOA present", got)
+	}
+	if got := negativeTTL(nil); got != 0 {
+		t.Errorf("negativeTTL(nil) = %d, want 0", got)
+	}
+}
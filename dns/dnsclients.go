@@ -10,13 +10,13 @@
 // TODO(rsc):
 //	Check periodically whether /etc/resolv.conf has changed.
 //	Could potentially handle many outstanding lookups faster.
-//	Could have a small cache.
 //	Random UDP source port (net.Dial should do that for us).
 //	Random request IDs.
 
 package dns
 
 import (
+	"context"
 	"github.com/op/go-logging"
 	"io"
 	"math/rand"
@@ -28,37 +28,44 @@ import (
 var noDeadline = time.Time{}
 var log = logging.MustGetLogger("")
 
-func check_black(name, server string, msg *dnsMsg, qtype uint16) bool {
-	if qtype != dnsTypeA {
-		return false
-	}
-	if len(cfg.servers) == 0 {
-		return false
-	}
-	cname, addrs, err := answer(name, server, msg, qtype)
-	if err != nil {
-		return false
-	}
-	if cname != name {
-		return false
-	}
-	if len(addrs) == 0 {
-		log.Debug("no such host recved")
-		return true
-	}
-	// all dns type A?
-	ips := convertRR_A(addrs)
-	if cfg.CheckBlack(ips) {
-		log.Debug("fake dns resolv hited.")
-		return true
+// ctxKey distinguishes this package's own context values from anything a
+// caller might have set.
+type ctxKey int
+
+// ctxKeyConsensusProbe marks a context used for consensusValidator's
+// synthetic cross-check query to another server. exchange checks for it
+// via runValidators and skips the validator pipeline when it's set, since
+// running consensusValidator again on the probe's reply would recurse
+// into probing yet more servers without bound.
+const ctxKeyConsensusProbe ctxKey = iota
+
+// runValidators reports whether exchange should run cfg's validator
+// pipeline over an answer arriving on ctx.
+func runValidators(ctx context.Context) bool {
+	return ctx.Value(ctxKeyConsensusProbe) == nil
+}
+
+// validatorsFor returns the anti-poisoning pipeline to run over answers
+// from cfg, built from cfg.validators if the config supplied one, or a
+// blacklist-only pipeline (the original check_black behavior) otherwise.
+func validatorsFor(cfg *dnsConfig) *validatorPipeline {
+	if len(cfg.validators) > 0 {
+		return &validatorPipeline{validators: cfg.validators}
 	}
-	return false
+	return &validatorPipeline{validators: []AnswerValidator{&blacklistValidator{cfg: cfg}}}
 }
 
 // Send a request on the connection and hope for a reply.
-// Up to cfg.attempts attempts.
-func exchange(cfg *dnsConfig, c net.Conn, name string, qtype uint16) (*dnsMsg, error) {
-	_, useTCP := c.(*net.TCPConn)
+// Up to cfg.attempts attempts. ctx bounds the whole exchange: it is
+// checked before each attempt and used to unblock the read once a
+// parallel lookup-strategy no longer needs this server's answer. framed
+// tells exchange whether c carries the 2-byte length-prefixed TCP DNS
+// framing (RFC 1035 4.2.2) -- true for both plain TCP and DNS-over-TLS,
+// which reuses TCP framing over its encrypted stream (RFC 7858 3.3).
+// c's concrete type cannot be used to infer this: a DoT connection is a
+// *tls.Conn, not a *net.TCPConn, even though it is framed.
+func exchange(ctx context.Context, cfg *dnsConfig, c net.Conn, framed bool, name string, qtype uint16) (*dnsMsg, error) {
+	useTCP := framed
 	if len(name) >= 256 {
 		return nil, &DNSError{Err: "name too long", Name: name}
 	}
@@ -72,6 +79,9 @@ func exchange(cfg *dnsConfig, c net.Conn, name string, qtype uint16) (*dnsMsg, e
 	if !ok {
 		return nil, &DNSError{Err: "internal error - cannot pack message", Name: name}
 	}
+	if cfg.edns0 != nil {
+		msg = appendEDNS0(msg, cfg.edns0)
+	}
 	if useTCP {
 		mlen := uint16(len(msg))
 		msg = append([]byte{byte(mlen >> 8), byte(mlen)}, msg...)
@@ -80,7 +90,24 @@ func exchange(cfg *dnsConfig, c net.Conn, name string, qtype uint16) (*dnsMsg, e
 	if a := c.RemoteAddr(); a != nil {
 		server = a.String()
 	}
+
+	// Closing c unblocks whichever Read/Write call is in flight, which is
+	// how a parallel lookup-strategy cancels the servers that lost.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+
 	for attempt := 0; attempt < cfg.attempts; attempt++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		sent := time.Now()
 		n, err := c.Write(msg)
 		if err != nil {
 			return nil, err
@@ -121,8 +148,16 @@ func exchange(cfg *dnsConfig, c net.Conn, name string, qtype uint16) (*dnsMsg, e
 			continue
 		}
 
-		if check_black(name, server, in, qtype) {
-			goto Reread
+		if runValidators(ctx) {
+			q := dnsQuestion{name, qtype, dnsClassINET}
+			info := ValidationInfo{Server: server, Elapsed: time.Since(sent)}
+			switch validatorsFor(cfg).Validate(q, in, info) {
+			case VerdictReject:
+				resolverCache.Flush()
+				return nil, &DNSError{Err: "answer rejected by anti-poisoning policy", Name: name, Server: server}
+			case VerdictDiscard:
+				goto Reread
+			}
 		}
 		return in, nil
 	}
@@ -132,42 +167,88 @@ func exchange(cfg *dnsConfig, c net.Conn, name string, qtype uint16) (*dnsMsg, e
 // Do a lookup for a single name, which must be rooted
 // (otherwise answer will not find the answers).
 func tryOneName(cfg *dnsConfig, name string, qtype uint16) (cname string, addrs []dnsRR, err error) {
+	if e, ok := resolverCache.get(name, qtype); ok {
+		if e.negative {
+			return "", nil, &DNSError{Err: noSuchHost, Name: name}
+		}
+		return e.cname, e.rrs, nil
+	}
+	key := cacheKey(name, qtype)
+	cname, addrs, err = resolverCache.sf.do(key, func() (string, []dnsRR, error) {
+		return tryOneNameUncached(cfg, name, qtype)
+	})
+	// NXDOMAIN and NODATA are cached negatively by tryNamesSequential /
+	// tryNamesParallel themselves, since only they still have the raw
+	// message (and thus the AUTHORITY-section SOA) the negative TTL
+	// comes from.
+	if err == nil && len(addrs) > 0 {
+		resolverCache.put(name, qtype, cname, addrs)
+	}
+	return
+}
+
+// tryOneNameUncached is the original, network-hitting implementation of
+// tryOneName; it is only reached on a resolverCache miss. The servers are
+// tried in an order, or all at once, according to cfg.lookupStrategy.
+func tryOneNameUncached(cfg *dnsConfig, name string, qtype uint16) (cname string, addrs []dnsRR, err error) {
 	if len(cfg.servers) == 0 {
 		return "", nil, &DNSError{Err: "no DNS servers", Name: name}
 	}
-	for i := 0; i < len(cfg.servers); i++ {
+	servers := orderServers(cfg)
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if cfg.timeout > 0 {
+		// cfg.timeout == 0 is the documented "wait forever" sentinel
+		// (exchange honors it via noDeadline); a wrapping context must
+		// not expire in that case either.
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(cfg.timeout)*time.Duration(cfg.attempts)*time.Second)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	if cfg.lookupStrategy == lookupParallel {
+		return tryNamesParallel(ctx, cfg, servers, name, qtype)
+	}
+	return tryNamesSequential(ctx, cfg, servers, name, qtype)
+}
+
+// newRequest builds the outgoing dnsMsg for one query attempt.
+func newRequest(name string, qtype uint16) *dnsMsg {
+	req := &dnsMsg{question: []dnsQuestion{{name, qtype, dnsClassINET}}}
+	req.id = uint16(rand.Int()) ^ uint16(time.Now().UnixNano())
+	req.recursion_desired = true
+	return req
+}
+
+// tryNamesSequential is the classic strategy: walk the (possibly
+// RTT-reordered) server list in turn, stopping at the first usable answer.
+func tryNamesSequential(ctx context.Context, cfg *dnsConfig, servers []string, name string, qtype uint16) (cname string, addrs []dnsRR, err error) {
+	for _, server := range servers {
 		// Calling Dial here is scary -- we have to be sure
 		// not to dial a name that will require a DNS lookup,
 		// or Dial will call back here to translate it.
 		// The DNS config parser has already checked that
 		// all the cfg.servers[i] are IP addresses, which
 		// Dial will use without a DNS lookup.
-		server := cfg.servers[i] + ":53"
-		c, cerr := net.Dial("udp", server)
-		if cerr != nil {
-			err = cerr
+		t, terr := newTransport(cfg, server)
+		if terr != nil {
+			err = terr
 			continue
 		}
-		msg, merr := exchange(cfg, c, name, qtype)
-		c.Close()
+		start := time.Now()
+		msg, merr := t.Exchange(ctx, newRequest(name, qtype))
+		serverRTT.observe(server, time.Since(start), merr == nil)
 		if merr != nil {
 			err = merr
 			continue
 		}
-		if msg.truncated { // see RFC 5966
-			c, cerr = net.Dial("tcp", server)
-			if cerr != nil {
-				err = cerr
-				continue
-			}
-			msg, merr = exchange(cfg, c, name, qtype)
-			c.Close()
-			if merr != nil {
-				err = merr
-				continue
-			}
-		}
 		cname, addrs, err = answer(name, server, msg, qtype)
+		if err == nil && len(addrs) == 0 || err != nil && err.(*DNSError).Err == noSuchHost {
+			// NODATA (err == nil, no records of this qtype) or
+			// NXDOMAIN: cache negatively, bounded by the authority
+			// section's SOA MINIMUM if the server sent one.
+			resolverCache.putNegative(name, qtype, negativeTTL(msg))
+		}
 		if err == nil || err.(*DNSError).Err == noSuchHost {
 			break
 		}
@@ -210,6 +291,9 @@ func lookup(name string, qtype uint16) (cname string, addrs []dnsRR, err error)
 		err = dnserr
 		return
 	}
+	if resolvedName, rrs, ok := staticLookup(cfg, name, qtype); ok {
+		return resolvedName, rrs, nil
+	}
 	// If name is rooted (trailing dot) or has enough dots,
 	// try it by itself first.
 	rooted := len(name) > 0 && name[len(name)-1] == '.'
@@ -0,0 +1,161 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+// DNS client configuration: parses /etc/goproxy/resolv.conf, the
+// resolv.conf-compatible config file this package's directives (plain
+// nameservers, the encrypted-transport schemes, EDNS0, lookup-strategy,
+// anti-poisoning, and the static hosts override) all read from.
+
+package dns
+
+import (
+	"bufio"
+	"encoding/hex"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// dnsConfig is the parsed form of /etc/goproxy/resolv.conf.
+type dnsConfig struct {
+	servers  []string // "nameserver" entries, in file order
+	search   []string // "domain"/"search" entries
+	ndots    int      // "options ndots:N", default 1
+	timeout  int      // "options timeout:N" seconds, 0 means wait forever
+	attempts int      // "options attempts:N", default 2
+
+	// CheckBlack reports whether a set of resolved A addresses looks
+	// like a forged answer, populated from "checkblack" lines.
+	CheckBlack func(ips []net.IP) bool
+
+	edns0          *ednsConfig
+	lookupStrategy lookupStrategy
+	validators     []AnswerValidator
+	dotPins        map[string][]byte // DoT hostname -> pinned SPKI SHA-256
+	hostsPath      string            // "hosts" directive override
+}
+
+// dnsReadConfig reads and parses a resolv.conf-style file into a
+// dnsConfig, applying the same defaults the original net package used
+// (ndots 1, timeout 5s, attempts 2) before directives are applied.
+func dnsReadConfig(filename string) (*dnsConfig, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	conf := &dnsConfig{
+		ndots:    1,
+		timeout:  5,
+		attempts: 2,
+		dotPins:  make(map[string][]byte),
+	}
+	var blacklist []net.IP
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "nameserver":
+			if len(fields) > 1 {
+				conf.servers = append(conf.servers, fields[1])
+			}
+		case "domain":
+			if len(fields) > 1 {
+				conf.search = []string{fields[1]}
+			}
+		case "search":
+			conf.search = append([]string(nil), fields[1:]...)
+		case "options":
+			parseOptions(conf, fields[1:])
+		case "lookup-strategy":
+			if len(fields) > 1 {
+				parseLookupStrategy(conf, fields[1])
+			}
+		case "validators":
+			if len(fields) > 1 {
+				parseValidatorsOption(conf, fields[1])
+			}
+		case "checkblack":
+			for _, a := range fields[1:] {
+				if ip := net.ParseIP(a); ip != nil {
+					blacklist = append(blacklist, ip)
+				}
+			}
+		case "dot-pin":
+			// dot-pin <host> <hex-encoded SHA-256 of the SPKI>
+			if len(fields) >= 3 {
+				if digest, err := hex.DecodeString(fields[2]); err == nil {
+					conf.dotPins[fields[1]] = digest
+				}
+			}
+		case "hosts":
+			if len(fields) > 1 {
+				conf.hostsPath = fields[1]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(blacklist) > 0 {
+		conf.CheckBlack = newIPBlacklist(blacklist)
+	} else {
+		conf.CheckBlack = func([]net.IP) bool { return false }
+	}
+	return conf, nil
+}
+
+// parseOptions applies "options ndots:N timeout:N attempts:N ..." fields
+// that every directive-specific file (edns0, lookup-strategy, ...) also
+// contributes entries to.
+func parseOptions(conf *dnsConfig, opts []string) {
+	for _, s := range opts {
+		switch {
+		case strings.HasPrefix(s, "ndots:"):
+			if n, err := strconv.Atoi(s[len("ndots:"):]); err == nil {
+				conf.ndots = n
+			}
+		case strings.HasPrefix(s, "timeout:"):
+			if n, err := strconv.Atoi(s[len("timeout:"):]); err == nil {
+				conf.timeout = n
+			}
+		case strings.HasPrefix(s, "attempts:"):
+			if n, err := strconv.Atoi(s[len("attempts:"):]); err == nil {
+				conf.attempts = n
+			}
+		default:
+			parseEDNS0Option(conf, s)
+		}
+	}
+}
+
+// newIPBlacklist builds the classic check_black predicate: true if every
+// resolved address is in the configured blacklist.
+func newIPBlacklist(blacklist []net.IP) func([]net.IP) bool {
+	return func(ips []net.IP) bool {
+		for _, ip := range ips {
+			hit := false
+			for _, b := range blacklist {
+				if ip.Equal(b) {
+					hit = true
+					break
+				}
+			}
+			if !hit {
+				return false
+			}
+		}
+		return len(ips) > 0
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package dns
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net"
+)
+
+// spkiSHA256 returns the SHA-256 digest of cert's subject public key info,
+// the quantity HPKP/DoT pinning configs usually encode.
+func spkiSHA256(cert *x509.Certificate) []byte {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return sum[:]
+}
+
+// dohJSONAnswer mirrors the "Answer" entries of the Google/Cloudflare
+// JSON DoH response format (RFC 8427-ish, never formally standardized).
+type dohJSONAnswer struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+type dohJSONResponse struct {
+	Status int             `json:"Status"`
+	Answer []dohJSONAnswer `json:"Answer"`
+}
+
+// decodeDoHJSON turns a JSON DoH body into the same *dnsMsg shape the wire
+// format produces, so callers never need to care which encoding was used.
+func decodeDoHJSON(r io.Reader, q dnsQuestion) (*dnsMsg, error) {
+	var resp dohJSONResponse
+	if err := json.NewDecoder(r).Decode(&resp); err != nil {
+		return nil, err
+	}
+	out := &dnsMsg{
+		dnsMsgHdr: dnsMsgHdr{
+			response:          true,
+			recursion_desired: true,
+			rcode:             resp.Status,
+		},
+		question: []dnsQuestion{q},
+	}
+	for _, a := range resp.Answer {
+		switch a.Type {
+		case dnsTypeA:
+			ip := net.ParseIP(a.Data).To4()
+			if ip == nil {
+				continue
+			}
+			out.answer = append(out.answer, &dnsRR_A{
+				Hdr: dnsRR_Header{Name: a.Name, Rrtype: dnsTypeA, Class: dnsClassINET, Ttl: a.TTL},
+				A:   uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3]),
+			})
+		case dnsTypeAAAA:
+			ip := net.ParseIP(a.Data).To16()
+			if ip == nil {
+				continue
+			}
+			var rr dnsRR_AAAA
+			rr.Hdr = dnsRR_Header{Name: a.Name, Rrtype: dnsTypeAAAA, Class: dnsClassINET, Ttl: a.TTL}
+			copy(rr.AAAA[:], ip)
+			out.answer = append(out.answer, &rr)
+		case dnsTypeCNAME:
+			out.answer = append(out.answer, &dnsRR_CNAME{
+				Hdr:   dnsRR_Header{Name: a.Name, Rrtype: dnsTypeCNAME, Class: dnsClassINET, Ttl: a.TTL},
+				Cname: a.Data,
+			})
+		}
+	}
+	return out, nil
+}
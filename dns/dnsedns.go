@@ -0,0 +1,74 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package dns
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	dnsTypeOPT = 41 // EDNS0 pseudo-RR, RFC 6891
+
+	defaultEDNS0BufSize = 4096
+)
+
+// ednsConfig carries the "options edns0 ..." directive parsed out of
+// resolv.conf: just the UDP payload size to advertise, for now.
+type ednsConfig struct {
+	bufSize uint16
+}
+
+// parseEDNS0Option applies one "options" field relevant to EDNS0:
+// "edns0" on its own enables it at the default buffer size, "bufsize:N"
+// sets the advertised UDP payload size.
+func parseEDNS0Option(conf *dnsConfig, s string) {
+	switch {
+	case s == "edns0":
+		if conf.edns0 == nil {
+			conf.edns0 = &ednsConfig{bufSize: defaultEDNS0BufSize}
+		}
+	case strings.HasPrefix(s, "bufsize:"):
+		if n, err := strconv.Atoi(s[len("bufsize:"):]); err == nil {
+			if conf.edns0 == nil {
+				conf.edns0 = &ednsConfig{}
+			}
+			conf.edns0.bufSize = uint16(n)
+		}
+	}
+}
+
+// appendEDNS0 appends an OPT pseudo-RR advertising edns.bufSize to an
+// already-packed DNS message, bumping the header's additional-record
+// count to match. It operates on the wire form directly so it can be
+// layered on top of dnsMsg.Pack without requiring EDNS0 awareness from
+// the base message codec.
+func appendEDNS0(packed []byte, edns *ednsConfig) []byte {
+	if len(packed) < 12 {
+		return packed
+	}
+	bufSize := edns.bufSize
+	if bufSize == 0 {
+		bufSize = defaultEDNS0BufSize
+	}
+
+	opt := make([]byte, 0, 11)
+	opt = append(opt, 0x00)                                  // NAME: root
+	opt = append(opt, byte(dnsTypeOPT>>8), byte(dnsTypeOPT)) // TYPE
+	opt = append(opt, byte(bufSize>>8), byte(bufSize))       // CLASS: requestor's UDP payload size
+	opt = append(opt, 0x00, 0x00, 0x00, 0x00)                // TTL: extended-rcode, version, flags (all zero)
+	opt = append(opt, 0x00, 0x00)                            // RDLENGTH: no options attached
+
+	out := make([]byte, len(packed)+len(opt))
+	copy(out, packed)
+	copy(out[len(packed):], opt)
+
+	arcount := uint16(out[10])<<8 | uint16(out[11])
+	arcount++
+	out[10], out[11] = byte(arcount>>8), byte(arcount)
+	return out
+}
@@ -0,0 +1,53 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package dns
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAppendEDNS0(t *testing.T) {
+	packed := make([]byte, 12) // minimal header, arcount == 0
+
+	out := appendEDNS0(packed, &ednsConfig{bufSize: 1232})
+
+	if len(out) != len(packed)+11 {
+		t.Fatalf("len(out) = %d, want %d (header + 11-byte OPT RR with no rdata)", len(out), len(packed)+11)
+	}
+	arcount := uint16(out[10])<<8 | uint16(out[11])
+	if arcount != 1 {
+		t.Fatalf("arcount = %d, want 1", arcount)
+	}
+	opt := out[12:]
+	if opt[0] != 0x00 {
+		t.Fatalf("OPT NAME = %#x, want root (0x00)", opt[0])
+	}
+	if rrtype := uint16(opt[1])<<8 | uint16(opt[2]); rrtype != dnsTypeOPT {
+		t.Fatalf("OPT TYPE = %d, want %d", rrtype, dnsTypeOPT)
+	}
+	if bufSize := uint16(opt[3])<<8 | uint16(opt[4]); bufSize != 1232 {
+		t.Fatalf("OPT CLASS (bufsize) = %d, want 1232", bufSize)
+	}
+}
+
+func TestAppendEDNS0DefaultsBufSize(t *testing.T) {
+	packed := make([]byte, 12)
+	out := appendEDNS0(packed, &ednsConfig{})
+	bufSize := uint16(out[15])<<8 | uint16(out[16])
+	if bufSize != defaultEDNS0BufSize {
+		t.Fatalf("bufsize = %d, want default %d", bufSize, defaultEDNS0BufSize)
+	}
+}
+
+func TestAppendEDNS0ShortPacketUnchanged(t *testing.T) {
+	packed := []byte{0x01, 0x02, 0x03}
+	out := appendEDNS0(packed, &ednsConfig{bufSize: 4096})
+	if !bytes.Equal(out, packed) {
+		t.Fatalf("appendEDNS0 modified a packet shorter than a DNS header")
+	}
+}
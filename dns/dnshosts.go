@@ -0,0 +1,315 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package dns
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const hostsFilePath = "/etc/goproxy/hosts"
+
+// hostsFile is a small static-override resolver layer consulted before
+// any network lookup: a /etc/hosts-style file plus wildcard suffix rules,
+// so the proxy can resolve internal names, pin known-good IPs for
+// sensitive hostnames (bypassing the anti-poisoning pipeline entirely for
+// those names), and give tests deterministic fixtures. It re-reads the
+// file whenever its mtime changes; this only covers the hosts override
+// file itself, not /etc/resolv.conf, which is still a separate TODO at
+// the top of dnsclients.go.
+type hostsFile struct {
+	mu sync.RWMutex
+
+	path    string
+	modTime time.Time
+
+	// exact maps a lower-cased, qtype-qualified name to its static values
+	// (IPs for A/AAAA, hostnames for CNAME).
+	exact map[string][]string
+	// suffixes holds "*.domain" rules, longest suffix first so the most
+	// specific wildcard wins.
+	suffixes []hostsSuffixRule
+}
+
+type hostsSuffixRule struct {
+	suffix string // ".internal.corp", including the leading dot
+	qtype  uint16
+	value  string // the IP or CNAME target every matching name resolves to
+}
+
+var staticHosts = &hostsFile{path: hostsFilePath}
+
+// lookupStatic consults the hosts file (reloading it first if it changed
+// on disk, or if cfg's "hosts" directive points somewhere else) and
+// returns the configured RRs for name/qtype, if any.
+func lookupStatic(cfg *dnsConfig, name string, qtype uint16) ([]dnsRR, bool) {
+	if cfg != nil && cfg.hostsPath != "" {
+		staticHosts.setPath(cfg.hostsPath)
+	}
+	staticHosts.reloadIfChanged()
+	return staticHosts.lookup(name, qtype)
+}
+
+// maxStaticCNAMEChain bounds how many hosts-file CNAME hops staticLookup
+// will follow, so a misconfigured loop (a CNAME b, b CNAME a) fails the
+// lookup instead of recursing forever.
+const maxStaticCNAMEChain = 8
+
+// staticLookup resolves name/qtype against the hosts override, following
+// a CNAME entry for name when there's no direct qtype match -- the
+// override equivalent of how goLookupIP itself threads a CNAME from a
+// real answer. It returns the name the static data actually answered
+// for (name itself, or the end of a CNAME chain), which lookup's caller
+// should report instead of the name that was originally queried.
+func staticLookup(cfg *dnsConfig, name string, qtype uint16) (resolvedName string, rrs []dnsRR, ok bool) {
+	for hop := 0; hop < maxStaticCNAMEChain; hop++ {
+		if rrs, ok := lookupStatic(cfg, name, qtype); ok {
+			return name, rrs, true
+		}
+		target, ok := lookupStaticCNAME(cfg, name)
+		if !ok {
+			return name, nil, false
+		}
+		name = target
+	}
+	return name, nil, false
+}
+
+// lookupStaticCNAME returns the target of a hosts-file CNAME override for
+// name, if one is configured.
+func lookupStaticCNAME(cfg *dnsConfig, name string) (string, bool) {
+	rrs, ok := lookupStatic(cfg, name, dnsTypeCNAME)
+	if !ok || len(rrs) == 0 {
+		return "", false
+	}
+	cname, ok := rrs[0].(*dnsRR_CNAME)
+	if !ok {
+		return "", false
+	}
+	return cname.Cname, true
+}
+
+// setPath switches the file staticHosts watches, forcing a reload on the
+// next lookup if the path actually changed.
+func (h *hostsFile) setPath(path string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.path == path {
+		return
+	}
+	h.path = path
+	h.modTime = time.Time{}
+}
+
+func hostsKey(name string, qtype uint16) string {
+	return strconv.Itoa(int(qtype)) + "/" + strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func (h *hostsFile) lookup(name string, qtype uint16) ([]dnsRR, bool) {
+	lookupName := strings.ToLower(strings.TrimSuffix(name, "."))
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if values, ok := h.exact[hostsKey(lookupName, qtype)]; ok {
+		return valuesToRR(name, qtype, values), true
+	}
+	for _, rule := range h.suffixes {
+		if rule.qtype != qtype {
+			continue
+		}
+		if strings.HasSuffix(lookupName, rule.suffix) {
+			return valuesToRR(name, qtype, []string{rule.value}), true
+		}
+	}
+	return nil, false
+}
+
+// reloadIfChanged re-parses the hosts file if its mtime has moved since
+// the last load, or loads it for the first time. Errors (most commonly
+// "file does not exist", since the override file is optional) are
+// swallowed: lookupStatic simply reports no static entry.
+func (h *hostsFile) reloadIfChanged() {
+	fi, err := os.Stat(h.path)
+	if err != nil {
+		return
+	}
+	h.mu.RLock()
+	unchanged := h.exact != nil && fi.ModTime().Equal(h.modTime)
+	h.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	f, err := os.Open(h.path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	exact := make(map[string][]string)
+	var suffixes []hostsSuffixRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parseHostsLine(scanner.Text(), exact, &suffixes)
+	}
+	// Longest suffix first, so "*.prod.internal.corp" is checked before
+	// the more general "*.internal.corp".
+	sortSuffixesLongestFirst(suffixes)
+
+	h.mu.Lock()
+	h.exact = exact
+	h.suffixes = suffixes
+	h.modTime = fi.ModTime()
+	h.mu.Unlock()
+}
+
+// parseHostsLine accepts four line shapes:
+//
+//	10.0.0.5 host.internal.corp           # classic /etc/hosts, any qtype
+//	*.internal.corp 10.0.0.5              # wildcard suffix rule
+//	host.internal.corp AAAA ::1           # per-qtype override
+//	host.internal.corp CNAME other.host.  # CNAME override, not an address
+//	*.internal.corp CNAME other.host.     # wildcard CNAME, same reasoning
+func parseHostsLine(line string, exact map[string][]string, suffixes *[]hostsSuffixRule) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return
+	}
+
+	if strings.HasPrefix(fields[0], "*.") {
+		suffix := fields[0][1:] // keep the leading dot, drop the star
+		// "*.domain QTYPE value" form, needed for CNAME (whose value
+		// isn't an address) and to pin an explicit A/AAAA.
+		if len(fields) >= 3 {
+			if qtype, ok := parseQtype(fields[1]); ok {
+				*suffixes = append(*suffixes, hostsSuffixRule{suffix: suffix, qtype: qtype, value: fields[2]})
+				return
+			}
+		}
+		// Classic "*.domain ip [QTYPE]" form: qtype inferred from the
+		// address, optionally overridden by a trailing qtype field.
+		ip := net.ParseIP(fields[1])
+		if ip == nil {
+			return
+		}
+		qtype := dnsTypeA
+		if ip.To4() == nil {
+			qtype = dnsTypeAAAA
+		}
+		if len(fields) >= 3 {
+			if t, ok := parseQtype(fields[2]); ok {
+				qtype = t
+			}
+		}
+		*suffixes = append(*suffixes, hostsSuffixRule{suffix: suffix, qtype: qtype, value: fields[1]})
+		return
+	}
+
+	if ip := net.ParseIP(fields[0]); ip != nil {
+		// Classic "ip name..." form.
+		qtype := uint16(dnsTypeA)
+		if ip.To4() == nil {
+			qtype = dnsTypeAAAA
+		}
+		for _, name := range fields[1:] {
+			key := hostsKey(name, qtype)
+			exact[key] = append(exact[key], fields[0])
+		}
+		return
+	}
+
+	// "name QTYPE value" form, for pinning a specific record type
+	// (including CNAME, which the classic "ip name..." form can't
+	// express since its value isn't an address).
+	if len(fields) >= 3 {
+		if qtype, ok := parseQtype(fields[1]); ok {
+			key := hostsKey(fields[0], qtype)
+			exact[key] = append(exact[key], fields[2])
+		}
+	}
+}
+
+func parseQtype(s string) (uint16, bool) {
+	switch strings.ToUpper(s) {
+	case "A":
+		return dnsTypeA, true
+	case "AAAA":
+		return dnsTypeAAAA, true
+	case "CNAME":
+		return dnsTypeCNAME, true
+	default:
+		return 0, false
+	}
+}
+
+func sortSuffixesLongestFirst(suffixes []hostsSuffixRule) {
+	for i := 1; i < len(suffixes); i++ {
+		for j := i; j > 0 && len(suffixes[j].suffix) > len(suffixes[j-1].suffix); j-- {
+			suffixes[j], suffixes[j-1] = suffixes[j-1], suffixes[j]
+		}
+	}
+}
+
+// valuesToRR wraps a hosts-file entry's stored values into the dnsRR shape
+// tryOneName's callers expect, stamping name (the name actually queried,
+// not necessarily the literal text of a wildcard rule) onto each header.
+func valuesToRR(name string, qtype uint16, values []string) []dnsRR {
+	rrs := make([]dnsRR, 0, len(values))
+	for _, v := range values {
+		if rr := hostRR(name, qtype, v); rr != nil {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs
+}
+
+// hostRR builds the single dnsRR a hosts-file line describes: an address
+// record if qtype is A/AAAA and value parses as a matching IP, or a CNAME
+// record pointing at value if qtype is CNAME. Returns nil if value doesn't
+// fit qtype.
+func hostRR(name string, qtype uint16, value string) dnsRR {
+	switch qtype {
+	case dnsTypeA:
+		ip := net.ParseIP(value)
+		v4 := ip.To4()
+		if v4 == nil {
+			return nil
+		}
+		return &dnsRR_A{
+			Hdr: dnsRR_Header{Name: name, Rrtype: dnsTypeA, Class: dnsClassINET, Ttl: 0},
+			A:   uint32(v4[0])<<24 | uint32(v4[1])<<16 | uint32(v4[2])<<8 | uint32(v4[3]),
+		}
+	case dnsTypeAAAA:
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil
+		}
+		v6 := ip.To16()
+		if v6 == nil {
+			return nil
+		}
+		var rr dnsRR_AAAA
+		rr.Hdr = dnsRR_Header{Name: name, Rrtype: dnsTypeAAAA, Class: dnsClassINET, Ttl: 0}
+		copy(rr.AAAA[:], v6)
+		return &rr
+	case dnsTypeCNAME:
+		return &dnsRR_CNAME{
+			Hdr:   dnsRR_Header{Name: name, Rrtype: dnsTypeCNAME, Class: dnsClassINET, Ttl: 0},
+			Cname: value,
+		}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package dns
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseHostsLineClassic(t *testing.T) {
+	exact := make(map[string][]string)
+	var suffixes []hostsSuffixRule
+
+	parseHostsLine("10.0.0.5 host.internal.corp alias.internal.corp", exact, &suffixes)
+
+	for _, name := range []string{"host.internal.corp", "alias.internal.corp"} {
+		values := exact[hostsKey(name, dnsTypeA)]
+		if len(values) != 1 || values[0] != "10.0.0.5" {
+			t.Errorf("exact[%q] = %v, want [10.0.0.5]", name, values)
+		}
+	}
+	if len(suffixes) != 0 {
+		t.Errorf("got %d suffix rules, want 0", len(suffixes))
+	}
+}
+
+func TestParseHostsLineWildcard(t *testing.T) {
+	exact := make(map[string][]string)
+	var suffixes []hostsSuffixRule
+
+	parseHostsLine("*.internal.corp 10.0.0.9", exact, &suffixes)
+
+	if len(suffixes) != 1 {
+		t.Fatalf("got %d suffix rules, want 1", len(suffixes))
+	}
+	rule := suffixes[0]
+	if rule.suffix != ".internal.corp" || rule.qtype != dnsTypeA || rule.value != "10.0.0.9" {
+		t.Errorf("suffixes[0] = %+v, want {.internal.corp %d 10.0.0.9}", rule, dnsTypeA)
+	}
+}
+
+func TestParseHostsLinePerQtype(t *testing.T) {
+	exact := make(map[string][]string)
+	var suffixes []hostsSuffixRule
+
+	parseHostsLine("host.internal.corp AAAA ::1", exact, &suffixes)
+	parseHostsLine("alias.internal.corp CNAME host.internal.corp.", exact, &suffixes)
+
+	if values := exact[hostsKey("host.internal.corp", dnsTypeAAAA)]; len(values) != 1 || values[0] != "::1" {
+		t.Errorf("AAAA entry = %v, want [::1]", values)
+	}
+	if values := exact[hostsKey("alias.internal.corp", dnsTypeCNAME)]; len(values) != 1 || values[0] != "host.internal.corp." {
+		t.Errorf("CNAME entry = %v, want [host.internal.corp.]", values)
+	}
+}
+
+func TestParseHostsLineCommentsAndBlank(t *testing.T) {
+	exact := make(map[string][]string)
+	var suffixes []hostsSuffixRule
+
+	parseHostsLine("# a comment", exact, &suffixes)
+	parseHostsLine("   ", exact, &suffixes)
+	parseHostsLine("not-enough-fields", exact, &suffixes)
+
+	if len(exact) != 0 || len(suffixes) != 0 {
+		t.Errorf("comment/blank/malformed lines added entries: exact=%v suffixes=%v", exact, suffixes)
+	}
+}
+
+func TestHostsFileLookupWildcardUsesQueriedName(t *testing.T) {
+	exact := make(map[string][]string)
+	var suffixes []hostsSuffixRule
+	parseHostsLine("*.internal.corp CNAME canonical.internal.corp.", exact, &suffixes)
+
+	h := &hostsFile{exact: exact, suffixes: suffixes}
+	rrs, ok := h.lookup("foo.internal.corp.", dnsTypeCNAME)
+	if !ok || len(rrs) != 1 {
+		t.Fatalf("lookup() = %v, %v, want one matching RR", rrs, ok)
+	}
+	cname, ok := rrs[0].(*dnsRR_CNAME)
+	if !ok {
+		t.Fatalf("rrs[0] is %T, want *dnsRR_CNAME", rrs[0])
+	}
+	if cname.Hdr.Name != "foo.internal.corp." {
+		t.Errorf("Hdr.Name = %q, want the queried name %q, not the wildcard rule's own text", cname.Hdr.Name, "foo.internal.corp.")
+	}
+	if cname.Cname != "canonical.internal.corp." {
+		t.Errorf("Cname = %q, want canonical.internal.corp.", cname.Cname)
+	}
+}
+
+func TestStaticLookupFollowsCNAME(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	contents := "host.internal.corp CNAME canonical.internal.corp.\n10.0.0.9 canonical.internal.corp\n"
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &dnsConfig{hostsPath: path}
+
+	// This is the same path lookup() takes for a real A/AAAA query: a
+	// CNAME override for the queried name must be followed to its
+	// target, not just be reachable by a caller that already knows to
+	// ask for dnsTypeCNAME directly.
+	resolvedName, rrs, ok := staticLookup(cfg, "host.internal.corp.", dnsTypeA)
+	if !ok {
+		t.Fatalf("staticLookup() ok = false, want true")
+	}
+	if resolvedName != "canonical.internal.corp." {
+		t.Errorf("resolvedName = %q, want canonical.internal.corp.", resolvedName)
+	}
+	if len(rrs) != 1 {
+		t.Fatalf("got %d RRs, want 1", len(rrs))
+	}
+	a, ok := rrs[0].(*dnsRR_A)
+	if !ok {
+		t.Fatalf("rrs[0] is %T, want *dnsRR_A", rrs[0])
+	}
+	got := net.IPv4(byte(a.A>>24), byte(a.A>>16), byte(a.A>>8), byte(a.A))
+	if got.String() != "10.0.0.9" {
+		t.Errorf("A = %s, want 10.0.0.9", got)
+	}
+}
+
+func TestStaticLookupBoundsCNAMELoop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	contents := "a.corp CNAME b.corp.\nb.corp CNAME a.corp.\n"
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &dnsConfig{hostsPath: path}
+
+	if _, _, ok := staticLookup(cfg, "a.corp.", dnsTypeA); ok {
+		t.Error("staticLookup() ok = true for a CNAME loop, want false")
+	}
+}
+
+func TestStaticLookupNoOverride(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte("10.0.0.5 host.internal.corp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &dnsConfig{hostsPath: path}
+
+	if _, _, ok := staticLookup(cfg, "unrelated.example.com.", dnsTypeA); ok {
+		t.Error("staticLookup() ok = true for a name with no hosts-file entry, want false")
+	}
+}
+
+func TestSortSuffixesLongestFirst(t *testing.T) {
+	suffixes := []hostsSuffixRule{
+		{suffix: ".corp"},
+		{suffix: ".prod.internal.corp"},
+		{suffix: ".internal.corp"},
+	}
+	sortSuffixesLongestFirst(suffixes)
+
+	for i := 1; i < len(suffixes); i++ {
+		if len(suffixes[i].suffix) > len(suffixes[i-1].suffix) {
+			t.Fatalf("suffixes not sorted longest-first: %v", suffixes)
+		}
+	}
+	if suffixes[0].suffix != ".prod.internal.corp" {
+		t.Errorf("suffixes[0] = %q, want the longest suffix first", suffixes[0].suffix)
+	}
+}
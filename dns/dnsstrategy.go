@@ -0,0 +1,167 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package dns
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// lookupStrategy selects how tryOneNameUncached spreads a query across
+// cfg.servers, set from the resolv.conf "lookup-strategy" directive.
+type lookupStrategy int
+
+const (
+	// lookupSequential tries servers one at a time, in resolv.conf order.
+	lookupSequential lookupStrategy = iota
+	// lookupRotate is sequential, but ordered by each server's recent
+	// EWMA round-trip time so a dead first nameserver stops costing a
+	// full timeout on every lookup.
+	lookupRotate
+	// lookupParallel fires the query at every configured server at once
+	// and returns as soon as any of them answers.
+	lookupParallel
+)
+
+// parseLookupStrategy applies the resolv.conf "lookup-strategy" directive
+// ("sequential", "rotate", or "parallel"); any other value is ignored and
+// leaves the default, lookupSequential, in place.
+func parseLookupStrategy(conf *dnsConfig, s string) {
+	switch s {
+	case "rotate":
+		conf.lookupStrategy = lookupRotate
+	case "parallel":
+		conf.lookupStrategy = lookupParallel
+	case "sequential":
+		conf.lookupStrategy = lookupSequential
+	}
+}
+
+// orderServers returns cfg.servers in the order tryOneNameUncached should
+// try them: unchanged for lookupSequential/lookupParallel, fastest-first
+// for lookupRotate.
+func orderServers(cfg *dnsConfig) []string {
+	if cfg.lookupStrategy != lookupRotate {
+		return cfg.servers
+	}
+	servers := append([]string(nil), cfg.servers...)
+	sort.SliceStable(servers, func(i, j int) bool {
+		return serverRTT.score(servers[i]) < serverRTT.score(servers[j])
+	})
+	return servers
+}
+
+// tryNamesParallel fires the query at every server simultaneously and
+// returns the first valid answer, cancelling the rest via ctx.
+func tryNamesParallel(ctx context.Context, cfg *dnsConfig, servers []string, name string, qtype uint16) (cname string, addrs []dnsRR, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		cname string
+		addrs []dnsRR
+		msg   *dnsMsg
+		err   error
+	}
+	results := make(chan result, len(servers))
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		server := server
+		t, terr := newTransport(cfg, server)
+		if terr != nil {
+			results <- result{err: terr}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			msg, merr := t.Exchange(ctx, newRequest(name, qtype))
+			serverRTT.observe(server, time.Since(start), merr == nil)
+			if merr != nil {
+				results <- result{err: merr}
+				return
+			}
+			cname, addrs, aerr := answer(name, server, msg, qtype)
+			results <- result{cname: cname, addrs: addrs, msg: msg, err: aerr}
+		}()
+	}
+	go func() { wg.Wait(); close(results) }()
+
+	for r := range results {
+		if r.err == nil && len(r.addrs) == 0 {
+			// NODATA: this server is authoritative for the name but has
+			// nothing of this qtype. Worth caching negatively, but keep
+			// waiting in case a different server answers positively.
+			resolverCache.putNegative(name, qtype, negativeTTL(r.msg))
+			continue
+		}
+		if r.err == nil {
+			return r.cname, r.addrs, nil
+		}
+		if dnsErr, ok := r.err.(*DNSError); ok && dnsErr.Err == noSuchHost {
+			resolverCache.putNegative(name, qtype, negativeTTL(r.msg))
+			return "", nil, r.err
+		}
+		err = r.err
+	}
+	return "", nil, err
+}
+
+// rttTracker keeps an exponentially-weighted moving average of each
+// server's round-trip time, plus a running count of consecutive
+// failures, so lookupRotate can order retries toward servers that have
+// actually been answering quickly.
+type rttTracker struct {
+	mu      sync.Mutex
+	samples map[string]*rttSample
+}
+
+type rttSample struct {
+	ewma     time.Duration
+	failures int
+}
+
+// alpha is the EWMA smoothing factor: higher weights recent samples more.
+const rttAlpha = 0.3
+
+var serverRTT = &rttTracker{samples: make(map[string]*rttSample)}
+
+func (t *rttTracker) observe(server string, rtt time.Duration, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, found := t.samples[server]
+	if !found {
+		s = &rttSample{ewma: rtt}
+		t.samples[server] = s
+	}
+	if !ok {
+		s.failures++
+		return
+	}
+	s.failures = 0
+	s.ewma = time.Duration(float64(s.ewma)*(1-rttAlpha) + float64(rtt)*rttAlpha)
+}
+
+// score ranks a server for ordering purposes: failing servers sort last,
+// otherwise lower EWMA RTT sorts first. Unseen servers get a neutral
+// score so they are tried before a server with a known bad track record.
+func (t *rttTracker) score(server string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.samples[server]
+	if !ok {
+		return 0
+	}
+	if s.failures > 0 {
+		return s.ewma + time.Duration(s.failures)*time.Second
+	}
+	return s.ewma
+}
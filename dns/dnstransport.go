@@ -0,0 +1,360 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transport exchanges a single DNS message with one upstream resolver.
+// UDP, TCP, DNS-over-TLS (DoT) and DNS-over-HTTPS (DoH) all implement it,
+// so tryOneName does not need to know which wire format a configured
+// nameserver actually speaks.
+type Transport interface {
+	// Exchange sends msg to the upstream server and returns its reply,
+	// or an error if no usable reply was received. Exchange must return
+	// promptly once ctx is done, so a parallel lookup-strategy can cancel
+	// the servers that lost the race.
+	Exchange(ctx context.Context, msg *dnsMsg) (*dnsMsg, error)
+}
+
+// transportCache holds one long-lived Transport per resolv.conf server
+// entry, keyed by the raw server string. DoH's pooled, HTTP/2-capable
+// *http.Client and DoT's reusable TLS connection both depend on the same
+// Transport instance being used across calls -- building a fresh one per
+// query, as newTransport alone would, defeats both.
+var transportCache sync.Map // server string -> Transport
+
+// newTransport returns the cached Transport for a resolv.conf
+// "nameserver" entry, building one on first use. server is one of:
+//
+//	1.2.3.4              plain UDP, falling back to TCP on truncation
+//	tls://1.2.3.4[:853]  DNS-over-TLS
+//	https://host/path    DNS-over-HTTPS (RFC 8484 wire format)
+func newTransport(cfg *dnsConfig, server string) (Transport, error) {
+	if t, ok := transportCache.Load(server); ok {
+		return t.(Transport), nil
+	}
+	t, err := buildTransport(cfg, server)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := transportCache.LoadOrStore(server, t)
+	return actual.(Transport), nil
+}
+
+func buildTransport(cfg *dnsConfig, server string) (Transport, error) {
+	switch {
+	case strings.HasPrefix(server, "https://"):
+		return newDoHTransport(cfg, server)
+	case strings.HasPrefix(server, "tls://"):
+		return newDoTTransport(cfg, strings.TrimPrefix(server, "tls://"))
+	default:
+		return &dialTransport{cfg: cfg, addr: transportAddr(server)}, nil
+	}
+}
+
+// transportAddr returns the address the connection buildTransport opens
+// for server will report as its RemoteAddr(), i.e. the form info.Server
+// (see exchange) actually takes for that resolv.conf entry -- so callers
+// like consensusValidator can tell whether a given info.Server came from
+// a particular configured server.
+func transportAddr(server string) string {
+	switch {
+	case strings.HasPrefix(server, "https://"):
+		return server
+	case strings.HasPrefix(server, "tls://"):
+		host, port, err := net.SplitHostPort(strings.TrimPrefix(server, "tls://"))
+		if err != nil {
+			host, port = strings.TrimPrefix(server, "tls://"), "853"
+		}
+		return net.JoinHostPort(host, port)
+	default:
+		// net.JoinHostPort brackets a bare IPv6 literal the same way
+		// RemoteAddr().String() does; server+":53" would not.
+		return net.JoinHostPort(server, "53")
+	}
+}
+
+// dialTransport is the classic plain UDP/TCP transport: it dials a fresh
+// connection per exchange and reuses the existing exchange() machinery.
+type dialTransport struct {
+	cfg  *dnsConfig
+	addr string
+}
+
+func (t *dialTransport) Exchange(ctx context.Context, msg *dnsMsg) (*dnsMsg, error) {
+	name := msg.question[0].Name
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "udp", t.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	in, err := exchange(ctx, t.cfg, c, false, name, msg.question[0].Qtype)
+	if err != nil {
+		return nil, err
+	}
+	if in.truncated {
+		tc, err := d.DialContext(ctx, "tcp", t.addr)
+		if err != nil {
+			return nil, err
+		}
+		defer tc.Close()
+		return exchange(ctx, t.cfg, tc, true, name, msg.question[0].Qtype)
+	}
+	return in, nil
+}
+
+// dotTransport speaks plain DNS framed over a long-lived TLS connection
+// to port 853 (RFC 7858). The connection is kept open and reused across
+// Exchange calls rather than redialed every time; it is only replaced
+// once a write or read on it actually fails. io serializes the whole
+// exchange round trip, since concurrent callers sharing one connection
+// would otherwise scramble each other's TCP-DNS framing; it is a
+// size-1 channel rather than a sync.Mutex so a caller with a short ctx
+// (e.g. consensusValidator's bounded peer probe) can give up on a busy
+// connection instead of blocking past its own deadline.
+type dotTransport struct {
+	cfg        *dnsConfig
+	addr       string
+	serverName string
+	pinnedCert []byte // optional SHA-256 SPKI pin, hex-decoded
+
+	io chan struct{} // size 1; held for the duration of one Exchange call
+
+	conn net.Conn
+}
+
+func newDoTTransport(cfg *dnsConfig, server string) (*dotTransport, error) {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		host, port = server, "853"
+	}
+	return &dotTransport{
+		cfg:        cfg,
+		addr:       net.JoinHostPort(host, port),
+		serverName: host,
+		pinnedCert: cfg.dotPins[host],
+		io:         make(chan struct{}, 1),
+	}, nil
+}
+
+func (t *dotTransport) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: time.Duration(t.cfg.timeout) * time.Second},
+		Config:    &tls.Config{ServerName: t.serverName},
+	}
+	if t.pinnedCert != nil {
+		dialer.Config.InsecureSkipVerify = true
+		dialer.Config.VerifyPeerCertificate = t.verifyPin
+	}
+	return dialer.DialContext(ctx, "tcp", t.addr)
+}
+
+func (t *dotTransport) Exchange(ctx context.Context, msg *dnsMsg) (*dnsMsg, error) {
+	// Wait for exclusive use of the connection, but give up once ctx
+	// says to: a caller on a short deadline (a consensus probe) must not
+	// be stuck waiting on a connection some other, longer-lived caller
+	// is holding.
+	select {
+	case t.io <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-t.io }()
+
+	conn := t.conn
+	if conn == nil {
+		var err error
+		conn, err = t.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		t.conn = conn
+	}
+
+	// DoT always carries TCP-framed DNS messages over the TLS stream
+	// (RFC 7858 3.3), regardless of what type wraps the socket.
+	in, err := exchange(ctx, t.cfg, conn, true, msg.question[0].Name, msg.question[0].Qtype)
+	if err != nil {
+		// exchange closes conn to unblock a cancelled read/write, so a
+		// caller's own context expiring looks the same here as the peer
+		// idle-closing the connection: either way, redial once before
+		// giving up. Because t.io is held for the whole round trip
+		// above, no *other* caller's cancellation can have done this out
+		// from under us.
+		if t.conn == conn {
+			t.conn = nil
+		}
+		conn.Close()
+
+		conn, derr := t.dial(ctx)
+		if derr != nil {
+			return nil, err
+		}
+		t.conn = conn
+		return exchange(ctx, t.cfg, conn, true, msg.question[0].Name, msg.question[0].Qtype)
+	}
+	return in, nil
+}
+
+// verifyPin rejects the handshake unless one of the presented certificates'
+// SPKI hashes matches the configured pin.
+func (t *dotTransport) verifyPin(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(spkiSHA256(cert), t.pinnedCert) {
+			return nil
+		}
+	}
+	return fmt.Errorf("dns: no certificate matched pinned key for %s", t.serverName)
+}
+
+// dohTransport speaks DNS-over-HTTPS (RFC 8484) against a single endpoint,
+// reusing one *http.Client (and its pooled, HTTP/2-capable transport)
+// across exchanges so repeated lookups don't pay a new TLS handshake.
+type dohTransport struct {
+	endpoint string
+	useJSON  bool
+	useGet   bool
+	client   *http.Client
+}
+
+func newDoHTransport(cfg *dnsConfig, endpoint string) (*dohTransport, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &dohTransport{
+		endpoint: u.String(),
+		useJSON:  u.Query().Get("ct") == "json",
+		useGet:   u.Query().Get("method") == "get",
+		client: &http.Client{
+			Timeout:   time.Duration(cfg.timeout) * time.Second,
+			Transport: &http.Transport{ForceAttemptHTTP2: true, MaxIdleConnsPerHost: 4},
+		},
+	}, nil
+}
+
+// Exchange picks the wire format the "nameserver" line asked for:
+// "?ct=json" for Google/Cloudflare-style JSON DoH, "?method=get" for the
+// RFC 8484 GET variant (for servers that won't accept POST bodies, or
+// that cache GET requests), and a wire-format POST otherwise.
+func (t *dohTransport) Exchange(ctx context.Context, msg *dnsMsg) (*dnsMsg, error) {
+	switch {
+	case t.useJSON:
+		return t.exchangeJSON(ctx, msg)
+	case t.useGet:
+		return t.exchangeWireGET(ctx, msg)
+	default:
+		return t.exchangeWire(ctx, msg)
+	}
+}
+
+// exchangeWire does an RFC 8484 POST with a raw, packed DNS message.
+func (t *dohTransport) exchangeWire(ctx context.Context, msg *dnsMsg) (*dnsMsg, error) {
+	packed, ok := msg.Pack()
+	if !ok {
+		return nil, &DNSError{Err: "internal error - cannot pack message", Name: msg.question[0].Name}
+	}
+	req, err := http.NewRequest("POST", t.endpoint, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	in := new(dnsMsg)
+	if !in.Unpack(body) {
+		return nil, &DNSError{Err: "cannot unpack DoH response", Name: msg.question[0].Name}
+	}
+	return in, nil
+}
+
+// exchangeWireGET is the RFC 8484 GET variant, used by servers that do not
+// accept POST bodies; the packed message is base64url(no padding) encoded
+// into the "dns" query parameter.
+func (t *dohTransport) exchangeWireGET(ctx context.Context, msg *dnsMsg) (*dnsMsg, error) {
+	packed, ok := msg.Pack()
+	if !ok {
+		return nil, &DNSError{Err: "internal error - cannot pack message", Name: msg.question[0].Name}
+	}
+	u, err := url.Parse(t.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("dns", base64.RawURLEncoding.EncodeToString(packed))
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	in := new(dnsMsg)
+	if !in.Unpack(body) {
+		return nil, &DNSError{Err: "cannot unpack DoH response", Name: msg.question[0].Name}
+	}
+	return in, nil
+}
+
+// exchangeJSON speaks Google/Cloudflare-style JSON DoH (?ct=json).
+func (t *dohTransport) exchangeJSON(ctx context.Context, msg *dnsMsg) (*dnsMsg, error) {
+	q := msg.question[0]
+	u, err := url.Parse(t.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	query.Set("name", q.Name)
+	query.Set("type", fmt.Sprintf("%d", q.Qtype))
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/dns-json")
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return decodeDoHJSON(resp.Body, q)
+}
@@ -0,0 +1,26 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package dns
+
+import "testing"
+
+func TestTransportAddr(t *testing.T) {
+	cases := []struct {
+		server string
+		want   string
+	}{
+		{"8.8.8.8", "8.8.8.8:53"},
+		{"tls://1.1.1.1", "1.1.1.1:853"},
+		{"tls://1.1.1.1:8853", "1.1.1.1:8853"},
+		{"https://dns.example/dns-query", "https://dns.example/dns-query"},
+	}
+	for _, c := range cases {
+		if got := transportAddr(c.server); got != c.want {
+			t.Errorf("transportAddr(%q) = %q, want %q", c.server, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package dns
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Verdict is the outcome of running an AnswerValidator over a candidate
+// answer.
+type Verdict int
+
+const (
+	// VerdictAccept means the answer looks legitimate.
+	VerdictAccept Verdict = iota
+	// VerdictDiscard means the answer looks forged; the caller should
+	// throw it away and keep waiting for a better one on the same
+	// connection (what check_black historically did).
+	VerdictDiscard
+	// VerdictReject means the answer is bad enough that it is not worth
+	// waiting for a replacement; the caller should fail the query
+	// outright.
+	VerdictReject
+)
+
+// ValidationInfo carries the per-exchange context a validator needs but
+// that isn't part of the DNS message itself.
+type ValidationInfo struct {
+	Server  string        // upstream that produced msg
+	Elapsed time.Duration // how long this answer took to arrive
+}
+
+// AnswerValidator inspects a candidate answer to a question and decides
+// whether it should be trusted. Validators are meant to be cheap and
+// side-effect free so they can be composed in a pipeline and run on every
+// answer.
+type AnswerValidator interface {
+	Validate(q dnsQuestion, msg *dnsMsg, info ValidationInfo) Verdict
+}
+
+// parseValidatorsOption applies the resolv.conf "validators" directive: a
+// comma-separated list of validator names, each optionally taking a
+// ":arg" parameter, that becomes conf.validators -- replacing the
+// blacklist-only pipeline validatorsFor otherwise falls back to. Unknown
+// names and malformed arguments are skipped, the same tolerance
+// parseLookupStrategy and parseEDNS0Option give the rest of the file.
+//
+//	validators blacklist,bogon,timing:0.25,ttl:10,consensus:2
+func parseValidatorsOption(conf *dnsConfig, spec string) {
+	for _, tok := range strings.Split(spec, ",") {
+		name, arg := tok, ""
+		if i := strings.IndexByte(tok, ':'); i >= 0 {
+			name, arg = tok[:i], tok[i+1:]
+		}
+		switch name {
+		case "blacklist":
+			conf.validators = append(conf.validators, &blacklistValidator{cfg: conf})
+		case "bogon":
+			conf.validators = append(conf.validators, &bogonValidator{})
+		case "timing":
+			v := &timingValidator{}
+			if f, err := strconv.ParseFloat(arg, 64); err == nil {
+				v.minRatio = f
+			}
+			conf.validators = append(conf.validators, v)
+		case "ttl":
+			v := &ttlAnomalyValidator{}
+			if n, err := strconv.ParseUint(arg, 10, 32); err == nil {
+				v.minTTL = uint32(n)
+			}
+			conf.validators = append(conf.validators, v)
+		case "consensus":
+			// required must be explicit and at least 2; with no peers to
+			// agree with, the validator is a no-op (see its Validate).
+			if n, err := strconv.Atoi(arg); err == nil && n >= 2 {
+				conf.validators = append(conf.validators, &consensusValidator{cfg: conf, required: n})
+			}
+		}
+	}
+}
+
+// validatorPipeline runs a list of validators in order and returns the
+// most severe verdict any of them produced (VerdictReject beats
+// VerdictDiscard beats VerdictAccept).
+type validatorPipeline struct {
+	validators []AnswerValidator
+}
+
+func (p *validatorPipeline) Validate(q dnsQuestion, msg *dnsMsg, info ValidationInfo) Verdict {
+	worst := VerdictAccept
+	for _, v := range p.validators {
+		switch v.Validate(q, msg, info) {
+		case VerdictReject:
+			return VerdictReject
+		case VerdictDiscard:
+			worst = VerdictDiscard
+		}
+	}
+	return worst
+}
+
+// blacklistValidator is the original check_black behavior, generalized:
+// reject (well, discard) an A answer whose addresses match cfg.CheckBlack.
+type blacklistValidator struct {
+	cfg *dnsConfig
+}
+
+func (v *blacklistValidator) Validate(q dnsQuestion, msg *dnsMsg, info ValidationInfo) Verdict {
+	if q.Qtype != dnsTypeA {
+		return VerdictAccept
+	}
+	cname, addrs, err := answer(q.Name, info.Server, msg, q.Qtype)
+	if err != nil || cname != q.Name {
+		return VerdictAccept
+	}
+	if len(addrs) == 0 {
+		log.Debug("no such host recved")
+		return VerdictDiscard
+	}
+	if v.cfg.CheckBlack(convertRR_A(addrs)) {
+		log.Debug("fake dns resolv hited.")
+		return VerdictDiscard
+	}
+	return VerdictAccept
+}
+
+// bogonValidator flags A/AAAA answers that resolve a public-looking name
+// to a private or otherwise reserved address (RFC 1918, RFC 6890):
+// a classic sign of an on-path forger returning a sinkhole address.
+type bogonValidator struct{}
+
+var bogonNets = mustParseCIDRs(
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", // RFC 1918
+	"127.0.0.0/8", "169.254.0.0/16", "0.0.0.0/8", // RFC 6890
+	"::1/128", "fc00::/7", "fe80::/10", // RFC 6890 (v6)
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+func isBogon(ip net.IP) bool {
+	for _, n := range bogonNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bogonValidator) Validate(q dnsQuestion, msg *dnsMsg, info ValidationInfo) Verdict {
+	if q.Qtype != dnsTypeA && q.Qtype != dnsTypeAAAA {
+		return VerdictAccept
+	}
+	for _, rr := range msg.answer {
+		var ip net.IP
+		switch r := rr.(type) {
+		case *dnsRR_A:
+			ip = net.IPv4(byte(r.A>>24), byte(r.A>>16), byte(r.A>>8), byte(r.A))
+		case *dnsRR_AAAA:
+			ip = append(net.IP(nil), r.AAAA[:]...)
+		default:
+			continue
+		}
+		if isBogon(ip) {
+			return VerdictDiscard
+		}
+	}
+	return VerdictAccept
+}
+
+// timingValidator flags answers that arrive implausibly fast given the
+// path's established RTT baseline: an off-path forger racing the real
+// answer usually wins by a wide margin, since it skips the round trip to
+// the real resolver entirely.
+type timingValidator struct {
+	// minRatio is how much faster than the EWMA baseline an answer is
+	// allowed to be before it's treated as suspicious.
+	minRatio float64
+}
+
+func (v *timingValidator) Validate(q dnsQuestion, msg *dnsMsg, info ValidationInfo) Verdict {
+	baseline := serverRTT.score(info.Server)
+	if baseline <= 0 || info.Elapsed <= 0 {
+		return VerdictAccept // no baseline yet, nothing to compare against
+	}
+	ratio := v.minRatio
+	if ratio <= 0 {
+		ratio = 0.125 // e.g. 10ms answer against an 80ms baseline
+	}
+	if float64(info.Elapsed) < float64(baseline)*ratio {
+		return VerdictDiscard
+	}
+	return VerdictAccept
+}
+
+// ttlAnomalyValidator flags the fixed, suspiciously low TTLs commonly
+// used by injection boxes (a legitimate authoritative TTL is rarely the
+// same single-digit-second value across every record in the answer).
+type ttlAnomalyValidator struct {
+	minTTL uint32
+}
+
+func (v *ttlAnomalyValidator) Validate(q dnsQuestion, msg *dnsMsg, info ValidationInfo) Verdict {
+	minTTL := v.minTTL
+	if minTTL == 0 {
+		minTTL = 5
+	}
+	if len(msg.answer) == 0 {
+		return VerdictAccept
+	}
+	first := msg.answer[0].Header().Ttl
+	if first >= minTTL {
+		return VerdictAccept
+	}
+	for _, rr := range msg.answer[1:] {
+		if rr.Header().Ttl != first {
+			return VerdictAccept // not uniform, likely legitimate
+		}
+	}
+	return VerdictDiscard
+}
+
+// consensusProbeTimeout bounds each peer cross-check consensusValidator
+// makes, independent of cfg.timeout: a probe to a DoT/DoH peer runs
+// while still holding that peer's own pooled-connection lock (see
+// dotTransport.Exchange), so a slow peer must not be able to stall a
+// healthy one for a full cfg.timeout*cfg.attempts.
+const consensusProbeTimeout = 2 * time.Second
+
+// consensusValidator requires that at least N of the configured resolvers
+// agree on the A set before an answer is trusted. It is the most
+// expensive validator (it queries other servers synchronously), so it
+// should be listed last in a pipeline.
+type consensusValidator struct {
+	cfg      *dnsConfig
+	required int
+}
+
+func (v *consensusValidator) Validate(q dnsQuestion, msg *dnsMsg, info ValidationInfo) Verdict {
+	if q.Qtype != dnsTypeA || v.required <= 1 {
+		return VerdictAccept
+	}
+	_, want, err := answer(q.Name, info.Server, msg, q.Qtype)
+	if err != nil {
+		return VerdictAccept
+	}
+	agree := 1
+	for _, server := range v.cfg.servers {
+		if transportAddr(server) == info.Server {
+			continue
+		}
+		t, terr := newTransport(v.cfg, server)
+		if terr != nil {
+			continue
+		}
+		// Mark this as a consensus probe so exchange skips the validator
+		// pipeline for it -- otherwise the reply would run
+		// consensusValidator again, which would probe yet more servers,
+		// recursing without bound. Bound its duration too: it runs while
+		// holding the peer's own transport lock, so it must not be
+		// allowed to block that peer's other callers indefinitely.
+		probeCtx, cancel := context.WithTimeout(
+			context.WithValue(context.Background(), ctxKeyConsensusProbe, true),
+			consensusProbeTimeout)
+		reply, merr := t.Exchange(probeCtx, newRequest(q.Name, q.Qtype))
+		cancel()
+		if merr != nil {
+			continue
+		}
+		_, got, aerr := answer(q.Name, server, reply, q.Qtype)
+		if aerr == nil && sameAddrSet(want, got) {
+			agree++
+		}
+		if agree >= v.required {
+			return VerdictAccept
+		}
+	}
+	return VerdictDiscard
+}
+
+func sameAddrSet(a, b []dnsRR) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	as, bs := convertRR_A(a), convertRR_A(b)
+	for _, x := range as {
+		found := false
+		for _, y := range bs {
+			if x.Equal(y) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
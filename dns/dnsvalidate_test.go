@@ -0,0 +1,168 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package dns
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseValidatorsOptionBuildsPipeline(t *testing.T) {
+	conf := &dnsConfig{}
+	parseValidatorsOption(conf, "blacklist,bogon,timing:0.5,ttl:10,consensus:2")
+
+	if len(conf.validators) != 5 {
+		t.Fatalf("got %d validators, want 5: %#v", len(conf.validators), conf.validators)
+	}
+	if _, ok := conf.validators[0].(*blacklistValidator); !ok {
+		t.Errorf("validators[0] is %T, want *blacklistValidator", conf.validators[0])
+	}
+	if _, ok := conf.validators[1].(*bogonValidator); !ok {
+		t.Errorf("validators[1] is %T, want *bogonValidator", conf.validators[1])
+	}
+	timing, ok := conf.validators[2].(*timingValidator)
+	if !ok || timing.minRatio != 0.5 {
+		t.Errorf("validators[2] = %#v, want *timingValidator{minRatio: 0.5}", conf.validators[2])
+	}
+	ttl, ok := conf.validators[3].(*ttlAnomalyValidator)
+	if !ok || ttl.minTTL != 10 {
+		t.Errorf("validators[3] = %#v, want *ttlAnomalyValidator{minTTL: 10}", conf.validators[3])
+	}
+	consensus, ok := conf.validators[4].(*consensusValidator)
+	if !ok || consensus.required != 2 || consensus.cfg != conf {
+		t.Errorf("validators[4] = %#v, want *consensusValidator{cfg: conf, required: 2}", conf.validators[4])
+	}
+}
+
+func TestParseValidatorsOptionSkipsUnknownAndMalformed(t *testing.T) {
+	conf := &dnsConfig{}
+	parseValidatorsOption(conf, "bogus,consensus:1,consensus:notanumber")
+
+	if len(conf.validators) != 0 {
+		t.Fatalf("got %d validators, want 0: %#v", len(conf.validators), conf.validators)
+	}
+}
+
+func TestValidatorsForUsesConfiguredPipeline(t *testing.T) {
+	cfg := &dnsConfig{validators: []AnswerValidator{&bogonValidator{}}}
+	p := validatorsFor(cfg)
+	if len(p.validators) != 1 {
+		t.Fatalf("got %d validators, want 1", len(p.validators))
+	}
+	if _, ok := p.validators[0].(*bogonValidator); !ok {
+		t.Errorf("validators[0] is %T, want *bogonValidator", p.validators[0])
+	}
+}
+
+func TestValidatorsForFallsBackToBlacklist(t *testing.T) {
+	cfg := &dnsConfig{}
+	p := validatorsFor(cfg)
+	if len(p.validators) != 1 {
+		t.Fatalf("got %d validators, want 1", len(p.validators))
+	}
+	if _, ok := p.validators[0].(*blacklistValidator); !ok {
+		t.Errorf("validators[0] is %T, want *blacklistValidator", p.validators[0])
+	}
+}
+
+type fixedVerdictValidator struct {
+	verdict Verdict
+	called  bool
+}
+
+func (v *fixedVerdictValidator) Validate(dnsQuestion, *dnsMsg, ValidationInfo) Verdict {
+	v.called = true
+	return v.verdict
+}
+
+func TestValidatorPipelineWorstVerdictWins(t *testing.T) {
+	discard := &fixedVerdictValidator{verdict: VerdictDiscard}
+	reject := &fixedVerdictValidator{verdict: VerdictReject}
+	neverRuns := &fixedVerdictValidator{verdict: VerdictAccept}
+	p := &validatorPipeline{validators: []AnswerValidator{discard, reject, neverRuns}}
+
+	got := p.Validate(dnsQuestion{}, &dnsMsg{}, ValidationInfo{})
+	if got != VerdictReject {
+		t.Errorf("Validate() = %v, want VerdictReject", got)
+	}
+	if !discard.called || !reject.called {
+		t.Error("expected both discard and reject validators to run")
+	}
+	if neverRuns.called {
+		t.Error("pipeline should short-circuit on VerdictReject, but ran the validator after it")
+	}
+}
+
+func TestValidatorPipelineAllAccept(t *testing.T) {
+	p := &validatorPipeline{validators: []AnswerValidator{
+		&fixedVerdictValidator{verdict: VerdictAccept},
+		&fixedVerdictValidator{verdict: VerdictAccept},
+	}}
+	if got := p.Validate(dnsQuestion{}, &dnsMsg{}, ValidationInfo{}); got != VerdictAccept {
+		t.Errorf("Validate() = %v, want VerdictAccept", got)
+	}
+}
+
+func TestRunValidatorsSkipsConsensusProbe(t *testing.T) {
+	if !runValidators(context.Background()) {
+		t.Error("runValidators(plain context) = false, want true")
+	}
+	probeCtx := context.WithValue(context.Background(), ctxKeyConsensusProbe, true)
+	if runValidators(probeCtx) {
+		t.Error("runValidators(consensus-probe context) = true, want false")
+	}
+}
+
+func TestConsensusValidatorNoopWithoutEnoughRequired(t *testing.T) {
+	v := &consensusValidator{cfg: &dnsConfig{}, required: 1}
+	q := dnsQuestion{Name: "example.com.", Qtype: dnsTypeA}
+	if got := v.Validate(q, &dnsMsg{}, ValidationInfo{}); got != VerdictAccept {
+		t.Errorf("Validate() = %v, want VerdictAccept when required <= 1", got)
+	}
+}
+
+func TestConsensusValidatorExcludesOriginatingServerByTransportAddr(t *testing.T) {
+	// info.Server is always a RemoteAddr().String() ("1.2.3.4:53"), never
+	// the raw resolv.conf entry ("1.2.3.4") -- the self-exclusion check
+	// must compare through transportAddr, or it never matches and the
+	// originating server gets re-probed as if it were an independent peer.
+	cfg := &dnsConfig{servers: []string{"9.9.9.9"}}
+	v := &consensusValidator{cfg: cfg, required: 2}
+	q := dnsQuestion{Name: "example.com.", Qtype: dnsTypeA}
+	msg := &dnsMsg{answer: []dnsRR{
+		&dnsRR_A{Hdr: dnsRR_Header{Name: "example.com.", Rrtype: dnsTypeA}, A: 0x01020304},
+	}}
+	// The only configured server is the one that produced this answer, so
+	// a correct self-exclusion check leaves no peers to probe.
+	got := v.Validate(q, msg, ValidationInfo{Server: transportAddr("9.9.9.9")})
+	if got != VerdictDiscard {
+		t.Errorf("Validate() = %v, want VerdictDiscard with no independent peers to corroborate", got)
+	}
+}
+
+func TestConsensusValidatorNoopForNonARecords(t *testing.T) {
+	v := &consensusValidator{cfg: &dnsConfig{servers: []string{"9.9.9.9"}}, required: 2}
+	q := dnsQuestion{Name: "example.com.", Qtype: dnsTypeAAAA}
+	if got := v.Validate(q, &dnsMsg{}, ValidationInfo{}); got != VerdictAccept {
+		t.Errorf("Validate() = %v, want VerdictAccept for a non-A question", got)
+	}
+}
+
+func TestSameAddrSet(t *testing.T) {
+	a := []dnsRR{&dnsRR_A{A: 0x01020304}}
+	b := []dnsRR{&dnsRR_A{A: 0x01020304}}
+	if !sameAddrSet(a, b) {
+		t.Error("sameAddrSet() = false for identical single-address sets, want true")
+	}
+	c := []dnsRR{&dnsRR_A{A: 0x05060708}}
+	if sameAddrSet(a, c) {
+		t.Error("sameAddrSet() = true for disjoint address sets, want false")
+	}
+	if sameAddrSet(a, append(b, c...)) {
+		t.Error("sameAddrSet() = true for sets of different length, want false")
+	}
+}